@@ -0,0 +1,266 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var jFlag = flag.Int("j", runtime.NumCPU(), "number of concurrent workers scanning package directories")
+var noCacheFlag = flag.Bool("no-cache", false, "disable the on-disk package cache under $XDG_CACHE_HOME/deadleaves")
+
+// cacheEntry is the serialized subset of a build.Package that's worth
+// caching: everything re-derivable cheaply (Dir, ImportPath, Goroot, ...)
+// via build.FindOnly is recomputed instead of stored.
+type cacheEntry struct {
+	Name         string   `json:"name"`
+	Imports      []string `json:"imports,omitempty"`
+	TestImports  []string `json:"testImports,omitempty"`
+	CgoFiles     []string `json:"cgoFiles,omitempty"`
+	TestGoFiles  []string `json:"testGoFiles,omitempty"`
+	XTestGoFiles []string `json:"xTestGoFiles,omitempty"`
+}
+
+// pkgCache memoizes directory -> *build.Package for one run (mem) and,
+// unless disabled, persists parsed results across runs in a
+// content-addressed cache keyed by the mtime+size of each Go file in the
+// directory, so an unchanged directory never needs reparsing.
+type pkgCache struct {
+	mem sync.Map // absolute dir -> *build.Package
+	dir string   // on-disk cache directory; "" disables persistence
+}
+
+func newPkgCache(noCache bool) *pkgCache {
+	c := &pkgCache{}
+	if noCache {
+		return c
+	}
+	if base, err := os.UserCacheDir(); err == nil {
+		c.dir = filepath.Join(base, "deadleaves")
+		os.MkdirAll(c.dir, 0755)
+	}
+	return c
+}
+
+// importDir returns the package at dir, consulting the in-memory and
+// on-disk caches before falling back to ctx.ImportDir.
+func (c *pkgCache) importDir(ctx *build.Context, dir string) (*build.Package, error) {
+	if v, ok := c.mem.Load(dir); ok {
+		return v.(*build.Package), nil
+	}
+	pkg, err := c.importDirUncached(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	c.mem.Store(dir, pkg)
+	return pkg, nil
+}
+
+// importPath resolves path (as imported from srcDir) to a directory and
+// then defers to importDir, so repeated imports of the same package still
+// hit the cache even though ctx.Import, not ctx.ImportDir, is the caller's
+// natural entry point.
+func (c *pkgCache) importPath(ctx *build.Context, path, srcDir string) (*build.Package, error) {
+	meta, err := ctx.Import(path, srcDir, build.FindOnly)
+	if err != nil {
+		return nil, err
+	}
+	return c.importDir(ctx, meta.Dir)
+}
+
+func (c *pkgCache) importDirUncached(ctx *build.Context, dir string) (*build.Package, error) {
+	key, keyErr := dirCacheKey(ctx, dir)
+	if c.dir != "" && keyErr == nil {
+		if entry, ok := c.readDisk(key); ok {
+			meta, err := ctx.ImportDir(dir, build.FindOnly)
+			if err == nil {
+				return entryToPackage(entry, meta), nil
+			}
+		}
+	}
+
+	pkg, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if c.dir != "" && keyErr == nil {
+		c.writeDisk(key, packageToEntry(pkg))
+	}
+	return pkg, nil
+}
+
+// dirCacheKey hashes the name, size and mtime of every *.go file in dir,
+// plus the build tags and GOOS/GOARCH a package is parsed under, so any
+// edit, addition or removal - or a change in how the directory is being
+// built - changes the key. Without the build-context part, two
+// invocations with different -tags (or different GOOS/GOARCH, or two
+// users sharing a machine) against the same on-disk cache would silently
+// serve each other's stale Imports.
+func dirCacheKey(ctx *build.Context, dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	type fileStamp struct {
+		name  string
+		size  int64
+		mtime int64
+	}
+	var stamps []fileStamp
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".go" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return "", err
+		}
+		stamps = append(stamps, fileStamp{e.Name(), info.Size(), info.ModTime().UnixNano()})
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i].name < stamps[j].name })
+
+	tags := append([]string{}, ctx.BuildTags...)
+	sort.Strings(tags)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "dir:%s\n", dir)
+	fmt.Fprintf(h, "goos:%s\ngoarch:%s\ntags:%s\n", ctx.GOOS, ctx.GOARCH, strings.Join(tags, ","))
+	for _, s := range stamps {
+		fmt.Fprintf(h, "%s:%d:%d\n", s.name, s.size, s.mtime)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func (c *pkgCache) cachePath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *pkgCache) readDisk(key string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(c.cachePath(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (c *pkgCache) writeDisk(key string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	os.WriteFile(c.cachePath(key), data, 0644)
+}
+
+func packageToEntry(pkg *build.Package) *cacheEntry {
+	return &cacheEntry{
+		Name:         pkg.Name,
+		Imports:      pkg.Imports,
+		TestImports:  pkg.TestImports,
+		CgoFiles:     pkg.CgoFiles,
+		TestGoFiles:  pkg.TestGoFiles,
+		XTestGoFiles: pkg.XTestGoFiles,
+	}
+}
+
+func entryToPackage(entry *cacheEntry, meta *build.Package) *build.Package {
+	pkg := *meta
+	pkg.Name = entry.Name
+	pkg.Imports = entry.Imports
+	pkg.TestImports = entry.TestImports
+	pkg.CgoFiles = entry.CgoFiles
+	pkg.TestGoFiles = entry.TestGoFiles
+	pkg.XTestGoFiles = entry.XTestGoFiles
+	return &pkg
+}
+
+// scanDirs walks roots and parses every Go package directory found,
+// fanning the parsing out across a bounded worker pool so a large tree's
+// packages are processed concurrently instead of one at a time. The final
+// merge into the returned map is done in a fixed, walk order, so that a
+// handful of directories sharing the same (degenerate) import path -
+// something go/build produces for build-tag-excluded trees outside any
+// module or GOPATH root - collapse the same way every run, regardless of
+// how the workers interleave.
+func scanDirs(ctx *build.Context, roots []string, cache *pkgCache, workers int) map[string]*build.Package {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var dirs []string
+	for _, root := range roots {
+		err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			dirs = append(dirs, p)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error walking %q: %v\n", root, err)
+		}
+	}
+
+	parsed := make(map[string]*build.Package, len(dirs))
+	var mu sync.Mutex
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range jobs {
+				pkg, err := cache.importDir(ctx, dir)
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				parsed[dir] = pkg
+				mu.Unlock()
+			}
+		}()
+	}
+	go func() {
+		for _, d := range dirs {
+			jobs <- d
+		}
+		close(jobs)
+	}()
+	wg.Wait()
+
+	pkgs := make(map[string]*build.Package, len(parsed))
+	for _, dir := range dirs {
+		if pkg, ok := parsed[dir]; ok {
+			pkgs[pkg.ImportPath] = pkg
+		}
+	}
+	return pkgs
+}
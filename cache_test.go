@@ -0,0 +1,131 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("package p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDirCacheKeyDiffersByDir is a regression test for a cache key collision
+// bug: two directories with identically-named, identically-sized,
+// identically-timestamped .go files used to hash to the same key, so one
+// directory's cached package would be served for the other.
+func TestDirCacheKeyDiffersByDir(t *testing.T) {
+	mtime := time.Now()
+	dirA := t.TempDir()
+	dirB := t.TempDir()
+	writeFile(t, filepath.Join(dirA, "p.go"), mtime)
+	writeFile(t, filepath.Join(dirB, "p.go"), mtime)
+
+	keyA, err := dirCacheKey(&build.Default, dirA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, err := dirCacheKey(&build.Default, dirB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyA == keyB {
+		t.Errorf("dirCacheKey(%q) == dirCacheKey(%q) == %q, want distinct keys", dirA, dirB, keyA)
+	}
+}
+
+func TestDirCacheKeyChangesOnEdit(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "p.go")
+	writeFile(t, file, time.Now())
+
+	before, err := dirCacheKey(&build.Default, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, file, time.Now().Add(time.Hour))
+	after, err := dirCacheKey(&build.Default, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Errorf("dirCacheKey did not change after the file's mtime changed")
+	}
+}
+
+// TestDirCacheKeyDiffersByBuildContext is a regression test for a cache
+// poisoning bug: the key didn't fold in -tags/GOOS/GOARCH, so two
+// invocations that scan the same directory under different build
+// contexts (different -tags, different GOOS/GOARCH, or two users sharing
+// a machine) would collide and silently serve each other's stale Imports.
+func TestDirCacheKeyDiffersByBuildContext(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "p.go"), time.Now())
+
+	untagged := build.Default
+	tagged := build.Default
+	tagged.BuildTags = []string{"tagged"}
+
+	keyUntagged, err := dirCacheKey(&untagged, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyTagged, err := dirCacheKey(&tagged, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyUntagged == keyTagged {
+		t.Errorf("dirCacheKey ignored BuildTags: same key %q for different -tags", keyUntagged)
+	}
+
+	otherArch := build.Default
+	otherArch.GOARCH = "this-is-not-" + build.Default.GOARCH
+	keyOtherArch, err := dirCacheKey(&otherArch, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyUntagged == keyOtherArch {
+		t.Errorf("dirCacheKey ignored GOARCH: same key %q for different architectures", keyUntagged)
+	}
+}
+
+func TestDirCacheKeyStableForSameInputs(t *testing.T) {
+	mtime := time.Now()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "p.go"), mtime)
+
+	first, err := dirCacheKey(&build.Default, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := dirCacheKey(&build.Default, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("dirCacheKey(%q) is not stable across calls: %q != %q", dir, first, second)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+)
+
+// visitState is the goinstall-style state of a package during the
+// reachability walk: unvisited, queued for expansion (visiting), or fully
+// expanded (done).
+type visitState int
+
+const (
+	unvisited visitState = iota
+	visiting
+	done
+)
+
+// depWalker computes, starting from a set of main roots, which packages are
+// reachable and the shortest import chain that reaches each one.
+type depWalker struct {
+	ctx     *build.Context
+	cache   *pkgCache
+	pkgs    map[string]*build.Package
+	used    map[string]bool
+	gitUsed map[string]bool
+	// parents maps an import path to the importer that first reached it on
+	// the shortest chain from a root; roots map to "".
+	parents map[string]string
+	states  map[string]visitState
+}
+
+func newDepWalker(ctx *build.Context, cache *pkgCache, pkgs map[string]*build.Package, gitUsed map[string]bool) *depWalker {
+	return &depWalker{
+		ctx:     ctx,
+		cache:   cache,
+		pkgs:    pkgs,
+		used:    make(map[string]bool),
+		gitUsed: gitUsed,
+		parents: make(map[string]string),
+		states:  make(map[string]visitState),
+	}
+}
+
+// resolveImport resolves an import path string to the package it names,
+// preferring the already-loaded pkgs index - which is what holds the real,
+// module/vendor/replace-aware answer for module-loaded packages - over
+// w.cache.importPath, which shells out to go/build.Context.Import and only
+// ever understands the legacy GOPATH layout.
+func (w *depWalker) resolveImport(p, srcDir string) (*build.Package, error) {
+	if dep, ok := w.pkgs[p]; ok {
+		return dep, nil
+	}
+	return w.cache.importPath(w.ctx, p, srcDir)
+}
+
+// recordDeps walks the reachability graph breadth-first from roots so that
+// w.parents records, for every package it reaches, the shortest import chain
+// from a root; this is the same unvisited/visiting/done bookkeeping
+// goinstall used to detect and order dependencies.
+func (w *depWalker) recordDeps(gits map[string]string, roots ...*build.Package) {
+	var queue []*build.Package
+	enqueue := func(pkg *build.Package, parent string) {
+		if w.states[pkg.ImportPath] != unvisited {
+			return
+		}
+		w.states[pkg.ImportPath] = visiting
+		w.used[pkg.ImportPath] = true
+		w.parents[pkg.ImportPath] = parent
+		if git := gits[pkg.ImportPath]; git != "" {
+			w.gitUsed[git] = true
+		}
+		queue = append(queue, pkg)
+	}
+
+	for _, root := range roots {
+		enqueue(root, "")
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+
+		imports := append([]string{}, pkg.Imports...)
+		imports = append(imports, pkg.TestImports...)
+		for _, p := range imports {
+			dep, err := w.resolveImport(p, pkg.Dir)
+			if err != nil {
+				if p != "C" {
+					fmt.Fprintf(os.Stderr, "package %q not found (imported by %q)\n", p, pkg.ImportPath)
+				}
+				continue
+			}
+			enqueue(dep, pkg.ImportPath)
+		}
+		w.states[pkg.ImportPath] = done
+	}
+}
+
+// chain returns the shortest import chain, root first, from a main root to
+// importPath, or nil if importPath was never reached.
+func (w *depWalker) chain(importPath string) []string {
+	if !w.used[importPath] {
+		return nil
+	}
+	var chain []string
+	for p := importPath; p != ""; p = w.parents[p] {
+		chain = append([]string{p}, chain...)
+	}
+	return chain
+}
@@ -0,0 +1,46 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"testing"
+)
+
+// TestRecordDepsUsesModulePkgs reproduces the bug where a module-loaded
+// root's import of another module-loaded package was resolved only through
+// w.cache.importPath (go/build.Context.Import), which cannot see into the
+// module cache and reported every such import as "not found". The unified
+// pkgs index, which already holds the real answer from go/packages, must be
+// consulted first.
+func TestRecordDepsUsesModulePkgs(t *testing.T) {
+	root := &build.Package{ImportPath: "example.com/m", Name: "main", Imports: []string{"example.com/m/sub"}}
+	sub := &build.Package{ImportPath: "example.com/m/sub", Name: "sub"}
+
+	pkgs := map[string]*build.Package{
+		root.ImportPath: root,
+		sub.ImportPath:  sub,
+	}
+
+	w := newDepWalker(&build.Default, newPkgCache(true), pkgs, make(map[string]bool))
+	w.recordDeps(nil, root)
+
+	if !w.used[sub.ImportPath] {
+		t.Errorf("recordDeps did not mark %q as used; module-loaded imports must resolve via the pkgs index", sub.ImportPath)
+	}
+	if got := w.chain(sub.ImportPath); len(got) != 2 || got[0] != root.ImportPath || got[1] != sub.ImportPath {
+		t.Errorf("chain(%q) = %v, want [%q %q]", sub.ImportPath, got, root.ImportPath, sub.ImportPath)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"path"
+)
+
+// nearestUsedAncestor climbs the directory tree from pkg.Dir towards
+// pkg.SrcRoot and returns the import path of the nearest ancestor directory
+// that holds a used package, so a reader can see which sibling keeps an
+// otherwise-unused package's git tree alive. It returns "" if there is none.
+func nearestUsedAncestor(pkg *build.Package, dirIndex map[string]*build.Package, used map[string]bool) string {
+	end := pkg.SrcRoot
+	if end == "" {
+		return ""
+	}
+	// end itself must be inspected, not just used as a stopping point: a
+	// package whose nearest used ancestor is the module/src root itself
+	// would otherwise never be reported.
+	for curr := path.Dir(pkg.Dir); len(curr) >= len(end); curr = path.Dir(curr) {
+		if anc, ok := dirIndex[curr]; ok && used[anc.ImportPath] {
+			return anc.ImportPath
+		}
+		if curr == end {
+			break
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"testing"
+)
+
+func TestNearestUsedAncestorAtSrcRoot(t *testing.T) {
+	root := &build.Package{ImportPath: "example.com/m", Dir: "/src/example.com/m", SrcRoot: "/src/example.com/m"}
+	sub := &build.Package{ImportPath: "example.com/m/sub", Dir: "/src/example.com/m/sub", SrcRoot: "/src/example.com/m"}
+
+	dirIndex := map[string]*build.Package{
+		root.Dir: root,
+		sub.Dir:  sub,
+	}
+	used := map[string]bool{root.ImportPath: true}
+
+	if got := nearestUsedAncestor(sub, dirIndex, used); got != root.ImportPath {
+		t.Errorf("nearestUsedAncestor(%+v) = %q, want %q (the module root itself is a used ancestor)", sub, got, root.ImportPath)
+	}
+}
+
+func TestNearestUsedAncestorNone(t *testing.T) {
+	sub := &build.Package{ImportPath: "example.com/m/sub", Dir: "/src/example.com/m/sub", SrcRoot: "/src/example.com/m"}
+	dirIndex := map[string]*build.Package{sub.Dir: sub}
+	used := map[string]bool{}
+
+	if got := nearestUsedAncestor(sub, dirIndex, used); got != "" {
+		t.Errorf("nearestUsedAncestor(%+v) = %q, want \"\"", sub, got)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"sort"
+)
+
+// pkgRecord is the JSON representation of one package in -format=json
+// output, mirroring the structured records external Go analyzers emit for
+// consumption by build systems.
+type pkgRecord struct {
+	ImportPath  string   `json:"importPath"`
+	Dir         string   `json:"dir,omitempty"`
+	IsMain      bool     `json:"isMain"`
+	IsStdlib    bool     `json:"isStdlib"`
+	GitRoot     string   `json:"gitRoot,omitempty"`
+	Used        bool     `json:"used"`
+	ImportedBy  []string `json:"importedBy,omitempty"`
+	Imports     []string `json:"imports,omitempty"`
+	TestImports []string `json:"testImports,omitempty"`
+}
+
+// jsonReport is the top-level -format=json document: the per-package
+// records plus a summary of what's unused.
+type jsonReport struct {
+	Packages       []pkgRecord `json:"packages"`
+	UnusedCount    int         `json:"unusedCount"`
+	UnusedGitTrees []string    `json:"unusedGitTrees,omitempty"`
+}
+
+// resolveImportGraph resolves every package's raw Imports and TestImports
+// into import paths present in pkgs, and builds the reverse (importedBy)
+// edges at the same time.
+func resolveImportGraph(ctx *build.Context, cache *pkgCache, pkgs map[string]*build.Package) (forward, testForward, importedBy map[string][]string) {
+	forward = make(map[string][]string)
+	testForward = make(map[string][]string)
+	importedBy = make(map[string][]string)
+
+	resolve := func(pkg *build.Package, rawImports []string) []string {
+		var resolved []string
+		for _, p := range rawImports {
+			if p == "C" {
+				continue
+			}
+			// Prefer the already-loaded pkgs index: it holds the real,
+			// module/vendor/replace-aware answer for module-loaded
+			// packages, which cache.importPath (go/build.Context.Import)
+			// cannot resolve.
+			dep, ok := pkgs[p]
+			if !ok {
+				var err error
+				dep, err = cache.importPath(ctx, p, pkg.Dir)
+				if err != nil {
+					continue
+				}
+			}
+			resolved = append(resolved, dep.ImportPath)
+			importedBy[dep.ImportPath] = append(importedBy[dep.ImportPath], pkg.ImportPath)
+		}
+		sort.Strings(resolved)
+		return resolved
+	}
+
+	for _, pkg := range pkgs {
+		forward[pkg.ImportPath] = resolve(pkg, pkg.Imports)
+		testForward[pkg.ImportPath] = resolve(pkg, pkg.TestImports)
+	}
+	for _, list := range importedBy {
+		sort.Strings(list)
+	}
+	return forward, testForward, importedBy
+}
+
+// writeJSON writes the -format=json report to w.
+func writeJSON(w io.Writer, ctx *build.Context, cache *pkgCache, pkgs map[string]*build.Package, gits map[string]string, gitUsed map[string]bool, used map[string]bool, reportStd bool) error {
+	forward, testForward, importedBy := resolveImportGraph(ctx, cache, pkgs)
+
+	report := jsonReport{}
+	for path, pkg := range pkgs {
+		report.Packages = append(report.Packages, pkgRecord{
+			ImportPath:  path,
+			Dir:         pkg.Dir,
+			IsMain:      pkg.Name == "main",
+			IsStdlib:    pkg.Goroot,
+			GitRoot:     gits[path],
+			Used:        used[path],
+			ImportedBy:  importedBy[path],
+			Imports:     forward[path],
+			TestImports: testForward[path],
+		})
+		if !used[path] && (!pkg.Goroot || reportStd) {
+			report.UnusedCount++
+		}
+	}
+	sort.Slice(report.Packages, func(i, j int) bool {
+		return report.Packages[i].ImportPath < report.Packages[j].ImportPath
+	})
+
+	for git, u := range gitUsed {
+		if !u {
+			report.UnusedGitTrees = append(report.UnusedGitTrees, git)
+		}
+	}
+	sort.Strings(report.UnusedGitTrees)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(report)
+}
+
+// writeDot writes a Graphviz digraph of the reachability graph restricted
+// to used packages, so a reader can see which main roots keep which
+// subtrees alive. Test-only edges are drawn dashed.
+func writeDot(w io.Writer, ctx *build.Context, cache *pkgCache, pkgs map[string]*build.Package, used map[string]bool) error {
+	forward, testForward, _ := resolveImportGraph(ctx, cache, pkgs)
+
+	var paths []string
+	for path := range pkgs {
+		if used[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintln(w, "digraph deadleaves {")
+	for _, path := range paths {
+		pkg := pkgs[path]
+		if pkg.Name == "main" {
+			fmt.Fprintf(w, "\t%q [shape=box,peripheries=2];\n", path)
+		}
+		for _, dep := range forward[path] {
+			if used[dep] {
+				fmt.Fprintf(w, "\t%q -> %q;\n", path, dep)
+			}
+		}
+		for _, dep := range testForward[path] {
+			if used[dep] {
+				fmt.Fprintf(w, "\t%q -> %q [style=dashed];\n", path, dep)
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
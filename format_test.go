@@ -0,0 +1,88 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"testing"
+)
+
+// TestResolveImportGraphUsesModulePkgs reproduces the bug where a
+// module-loaded package's imports were resolved only through
+// cache.importPath (go/build.Context.Import), which cannot see into the
+// module cache, so real edges were silently dropped from the JSON/dot
+// reachability graph.
+func TestResolveImportGraphUsesModulePkgs(t *testing.T) {
+	root := &build.Package{ImportPath: "example.com/m", Imports: []string{"example.com/m/sub"}}
+	sub := &build.Package{ImportPath: "example.com/m/sub"}
+
+	pkgs := map[string]*build.Package{
+		root.ImportPath: root,
+		sub.ImportPath:  sub,
+	}
+
+	forward, _, importedBy := resolveImportGraph(&build.Default, newPkgCache(true), pkgs)
+
+	if got := forward[root.ImportPath]; len(got) != 1 || got[0] != sub.ImportPath {
+		t.Errorf("forward[%q] = %v, want [%q]", root.ImportPath, got, sub.ImportPath)
+	}
+	if got := importedBy[sub.ImportPath]; len(got) != 1 || got[0] != root.ImportPath {
+		t.Errorf("importedBy[%q] = %v, want [%q]", sub.ImportPath, got, root.ImportPath)
+	}
+}
+
+func TestWriteJSONShape(t *testing.T) {
+	root := &build.Package{ImportPath: "example.com/m", Name: "main", Imports: []string{"example.com/m/sub"}}
+	sub := &build.Package{ImportPath: "example.com/m/sub"}
+
+	pkgs := map[string]*build.Package{
+		root.ImportPath: root,
+		sub.ImportPath:  sub,
+	}
+	gits := map[string]string{root.ImportPath: "/repo", sub.ImportPath: "/repo"}
+	gitUsed := map[string]bool{"/repo": true}
+	used := map[string]bool{root.ImportPath: true, sub.ImportPath: true}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, &build.Default, newPkgCache(true), pkgs, gits, gitUsed, used, false); err != nil {
+		t.Fatalf("writeJSON: %v", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("unmarshaling report: %v", err)
+	}
+	if report.UnusedCount != 0 {
+		t.Errorf("UnusedCount = %d, want 0 (both packages are used)", report.UnusedCount)
+	}
+	if len(report.UnusedGitTrees) != 0 {
+		t.Errorf("UnusedGitTrees = %v, want none", report.UnusedGitTrees)
+	}
+
+	byPath := make(map[string]pkgRecord, len(report.Packages))
+	for _, r := range report.Packages {
+		byPath[r.ImportPath] = r
+	}
+	rootRecord, ok := byPath[root.ImportPath]
+	if !ok || !rootRecord.IsMain || !rootRecord.Used || len(rootRecord.Imports) != 1 || rootRecord.Imports[0] != sub.ImportPath {
+		t.Errorf("root record = %+v, want IsMain/Used true and Imports [%q]", rootRecord, sub.ImportPath)
+	}
+	subRecord, ok := byPath[sub.ImportPath]
+	if !ok || len(subRecord.ImportedBy) != 1 || subRecord.ImportedBy[0] != root.ImportPath {
+		t.Errorf("sub record = %+v, want ImportedBy [%q]", subRecord, root.ImportPath)
+	}
+}
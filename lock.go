@@ -0,0 +1,122 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var lockFlag = flag.String("lock", "", "write a gitlock-style JSON lockfile of used git repos to this path")
+var ignoreFlag = flag.String("ignore", "", "comma-separated import path prefixes to exclude from -lock")
+
+// lockEntry describes one used git tree in a -lock lockfile.
+type lockEntry struct {
+	ImportPath string `json:"importPath"`
+	Dir        string `json:"dir"`
+	Remote     string `json:"remote,omitempty"`
+	Rev        string `json:"rev,omitempty"`
+	Dirty      bool   `json:"dirty,omitempty"`
+}
+
+// splitList splits a comma-separated flag value into its trimmed,
+// non-empty elements.
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// repoImportPrefix returns the import path that corresponds to gitRoot,
+// derived from a package known to live under it.
+func repoImportPrefix(pkg *build.Package, gitRoot string) string {
+	rel, err := filepath.Rel(gitRoot, pkg.Dir)
+	if err != nil || rel == "." {
+		return pkg.ImportPath
+	}
+	return strings.TrimSuffix(pkg.ImportPath, "/"+filepath.ToSlash(rel))
+}
+
+// writeLock walks the used git trees recorded in gits/gitUsed and writes a
+// deterministic JSON lockfile to path, in the spirit of gitlock's
+// Dockerfile-embedded "git fetch"/"checkout" records: enough to reproducibly
+// recreate the workspace from its transitive dependencies.
+func writeLock(path string, pkgs map[string]*build.Package, gits map[string]string, gitUsed map[string]bool, ignore []string) error {
+	prefixes := make(map[string]string) // git root -> import prefix
+	for importPath, git := range gits {
+		if _, ok := prefixes[git]; ok {
+			continue
+		}
+		prefixes[git] = repoImportPrefix(pkgs[importPath], git)
+	}
+
+	var entries []lockEntry
+	for git, used := range gitUsed {
+		if !used {
+			continue
+		}
+		prefix := prefixes[git]
+		if ignored(prefix, ignore) {
+			continue
+		}
+		entry := lockEntry{ImportPath: prefix, Dir: git}
+		entry.Remote = gitOutput(git, "config", "--get", "remote.origin.url")
+		entry.Rev = gitOutput(git, "rev-parse", "HEAD")
+		entry.Dirty = gitOutput(git, "status", "--porcelain") != ""
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ImportPath < entries[j].ImportPath })
+
+	data, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshaling lockfile: %w", err)
+	}
+	data = append(data, '\n')
+	return os.WriteFile(path, data, 0644)
+}
+
+// ignored reports whether prefix matches one of the ignore prefixes.
+func ignored(prefix string, ignore []string) bool {
+	for _, ig := range ignore {
+		if prefix == ig || strings.HasPrefix(prefix, ig+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// gitOutput runs a git subcommand in dir and returns its trimmed stdout, or
+// "" if the command fails.
+func gitOutput(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
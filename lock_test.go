@@ -0,0 +1,90 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoImportPrefix(t *testing.T) {
+	root := &build.Package{ImportPath: "example.com/m", Dir: "/src/example.com/m"}
+	sub := &build.Package{ImportPath: "example.com/m/sub", Dir: "/src/example.com/m/sub"}
+
+	if got := repoImportPrefix(root, "/src/example.com/m"); got != "example.com/m" {
+		t.Errorf("repoImportPrefix(root) = %q, want %q", got, "example.com/m")
+	}
+	if got := repoImportPrefix(sub, "/src/example.com/m"); got != "example.com/m" {
+		t.Errorf("repoImportPrefix(sub) = %q, want %q", got, "example.com/m")
+	}
+}
+
+// TestWriteLockFindsModuleRoot is a regression test for the case where the
+// git root used by the lockfile is the package's own directory (the
+// findGit boundary fix): writeLock must still emit an entry for it.
+func TestWriteLockFindsModuleRoot(t *testing.T) {
+	gitRoot := t.TempDir()
+	pkg := &build.Package{ImportPath: "example.com/m", Dir: gitRoot}
+
+	pkgs := map[string]*build.Package{pkg.ImportPath: pkg}
+	gits := map[string]string{pkg.ImportPath: gitRoot}
+	gitUsed := map[string]bool{gitRoot: true}
+
+	out := filepath.Join(t.TempDir(), "lock.json")
+	if err := writeLock(out, pkgs, gits, gitUsed, nil); err != nil {
+		t.Fatalf("writeLock: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading lockfile: %v", err)
+	}
+	var entries []lockEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("unmarshaling lockfile: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ImportPath != pkg.ImportPath || entries[0].Dir != gitRoot {
+		t.Errorf("writeLock entries = %+v, want one entry for %q at %q", entries, pkg.ImportPath, gitRoot)
+	}
+}
+
+func TestWriteLockIgnoresPrefix(t *testing.T) {
+	gitRoot := t.TempDir()
+	pkg := &build.Package{ImportPath: "example.com/vendored", Dir: gitRoot}
+
+	pkgs := map[string]*build.Package{pkg.ImportPath: pkg}
+	gits := map[string]string{pkg.ImportPath: gitRoot}
+	gitUsed := map[string]bool{gitRoot: true}
+
+	out := filepath.Join(t.TempDir(), "lock.json")
+	if err := writeLock(out, pkgs, gits, gitUsed, []string{"example.com/vendored"}); err != nil {
+		t.Fatalf("writeLock: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading lockfile: %v", err)
+	}
+	var entries []lockEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("unmarshaling lockfile: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("writeLock entries = %+v, want none (ignored by -ignore)", entries)
+	}
+}
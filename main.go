@@ -17,6 +17,11 @@ limitations under the License.
 // Command deadleaves finds and prints the import paths of unused Go packages.
 // A package is considered unused if it is not a command ("package main") and
 // is not transitively imported by a command.
+//
+// When run from inside a module, or when given a "./..." pattern, deadleaves
+// loads packages with golang.org/x/tools/go/packages instead of walking
+// $GOPATH, so it understands modules, vendor directories and replace/exclude
+// directives; see modules.go.
 package main
 
 import (
@@ -25,12 +30,26 @@ import (
 	"go/build"
 	"os"
 	"path"
-	"path/filepath"
+	"strings"
 )
 
 var stdFlag = flag.Bool("std", false, "report unused standard packages")
 var installed = flag.Bool("installed", false, "only use installed binaries to check")
 var wholeGit = flag.Bool("git", false, "only report whole unused git trees")
+var whyFlag = flag.String("why", "", "print the shortest import chain from a main root to this import path")
+var explainFlag = flag.Bool("explain", false, "for each unused package, also print the nearest used ancestor directory")
+var formatFlag = flag.String("format", "text", "output format: text, json, or dot")
+
+// hasModulePattern reports whether any of args looks like a package pattern
+// (as opposed to a plain import path), which only go/packages understands.
+func hasModulePattern(args []string) bool {
+	for _, a := range args {
+		if strings.Contains(a, "...") || strings.HasPrefix(a, "./") || strings.HasPrefix(a, "../") {
+			return true
+		}
+	}
+	return false
+}
 
 func exists(path string) bool {
 	_, err := os.Stat(path)
@@ -38,20 +57,30 @@ func exists(path string) bool {
 }
 
 func findGit(pkg *build.Package) string {
-	start := pkg.Dir
 	end := pkg.SrcRoot
-	if len(end) == 0 {
+	if len(end) == 0 || len(pkg.Dir) == 0 {
 		return ""
 	}
-	curr := start
-	for curr != end {
-		dotGit := path.Join(curr, ".git")
-		if exists(dotGit) {
+	// end itself must be checked, not just skipped past: in module mode
+	// end is the module directory, which for the common single-repo,
+	// single-module layout is exactly where .git lives.
+	curr := pkg.Dir
+	for {
+		if exists(path.Join(curr, ".git")) {
 			return curr
 		}
-		curr = path.Dir(curr)
+		if curr == end {
+			return ""
+		}
+		parent := path.Dir(curr)
+		if parent == curr {
+			// Reached the filesystem root without ever meeting end, so end
+			// isn't actually an ancestor of pkg.Dir; stop instead of
+			// spinning forever.
+			return ""
+		}
+		curr = parent
 	}
-	return ""
 }
 
 func main() {
@@ -59,59 +88,61 @@ func main() {
 
 	flag.Parse()
 
+	if *tagsFlag != "" {
+		ctx.BuildTags = splitList(*tagsFlag)
+	}
+
 	gits := make(map[string]string)
 	pkgs := make(map[string]*build.Package)
 	gitUsed := make(map[string]bool)
-	for _, root := range ctx.SrcDirs() {
-		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
-			if !fi.IsDir() {
-				return nil
-			}
-			pkg, err := ctx.ImportDir(path, 0)
-			if err != nil {
-				return nil
-			}
-			pkgs[pkg.ImportPath] = pkg
-			if *wholeGit {
+
+	trackGit := *wholeGit || *lockFlag != "" || *formatFlag == "json"
+	cache := newPkgCache(*noCacheFlag)
+
+	args := flag.Args()
+	useModules := inModule() || hasModulePattern(args)
+	if useModules {
+		patterns := args
+		if len(patterns) == 0 {
+			patterns = []string{"./..."}
+		}
+		modPkgs, err := loadModulePackages(patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading modules: %v\n", err)
+		}
+		for importPath, pkg := range modPkgs {
+			pkgs[importPath] = pkg
+			if trackGit {
 				if g := findGit(pkg); g != "" {
-					gits[pkg.ImportPath] = g
+					gits[importPath] = g
 					gitUsed[g] = false
-
 				}
 			}
-			return nil
-		})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error walking %q: %v\n", root, err)
 		}
 	}
 
-	used := make(map[string]bool)
-	var recordDeps func(*build.Package)
-	recordDeps = func(pkg *build.Package) {
-		if used[pkg.ImportPath] {
-			return
-		}
-		used[pkg.ImportPath] = true
-		git := gits[pkg.ImportPath]
-		if len(git) > 0 {
-			gitUsed[git] = true
-		}
-		imports := append([]string{}, pkg.Imports...)
-		imports = append(imports, pkg.TestImports...)
-		for _, p := range imports {
-			dep, err := ctx.Import(p, pkg.Dir, 0)
-			if err != nil {
-				if p != "C" {
-					fmt.Fprintf(os.Stderr, "package %q not found (imported by %q)\n", p, pkg.ImportPath)
-				}
-				continue
+	for importPath, pkg := range scanDirs(&ctx, ctx.SrcDirs(), cache, *jFlag) {
+		pkgs[importPath] = pkg
+		if trackGit {
+			if g := findGit(pkg); g != "" {
+				gits[importPath] = g
+				gitUsed[g] = false
 			}
-			recordDeps(dep)
 		}
 	}
+
+	dirIndex := make(map[string]*build.Package, len(pkgs))
+	for _, pkg := range pkgs {
+		if pkg.Dir != "" {
+			dirIndex[pkg.Dir] = pkg
+		}
+	}
+
+	walker := newDepWalker(&ctx, cache, pkgs, gitUsed)
+	var roots []*build.Package
 	for _, pkg := range pkgs {
-		if pkg.Name == "main" {
+		switch {
+		case pkg.Name == "main":
 			if *installed {
 				b := path.Base(pkg.Dir)
 				bin := path.Join(pkg.BinDir, b)
@@ -120,25 +151,74 @@ func main() {
 					continue
 				}
 			}
-			recordDeps(pkg)
+			roots = append(roots, pkg)
+		case autoRoot(pkg):
+			roots = append(roots, pkg)
 		}
 	}
+	roots = append(roots, explicitRoots(pkgs, rootFlags)...)
+	if *rootsFromFlag != "" {
+		patterns, err := rootsFromFile(*rootsFromFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading -roots-from %q: %v\n", *rootsFromFlag, err)
+		} else {
+			roots = append(roots, explicitRoots(pkgs, patterns)...)
+		}
+	}
+	walker.recordDeps(gits, roots...)
+	used := walker.used
 
-	if *wholeGit {
-		for path, used := range gitUsed {
-			if used {
-				continue
-			}
-			fmt.Println(path)
+	if *whyFlag != "" {
+		chain := walker.chain(*whyFlag)
+		if chain == nil {
+			fmt.Fprintf(os.Stderr, "%q is not reachable from any main package\n", *whyFlag)
+			os.Exit(1)
 		}
-	} else {
-		for path, pkg := range pkgs {
-			if !used[path] {
-				if pkg.Goroot && !*stdFlag {
+		fmt.Println(strings.Join(chain, " -> "))
+		return
+	}
+
+	if *lockFlag != "" {
+		if err := writeLock(*lockFlag, pkgs, gits, gitUsed, splitList(*ignoreFlag)); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing lockfile %q: %v\n", *lockFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	switch *formatFlag {
+	case "json":
+		if err := writeJSON(os.Stdout, &ctx, cache, pkgs, gits, gitUsed, used, *stdFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing json: %v\n", err)
+			os.Exit(1)
+		}
+	case "dot":
+		if err := writeDot(os.Stdout, &ctx, cache, pkgs, used); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing dot: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		if *wholeGit {
+			for path, used := range gitUsed {
+				if used {
 					continue
 				}
 				fmt.Println(path)
 			}
+		} else if *lockFlag == "" {
+			for path, pkg := range pkgs {
+				if !used[path] {
+					if pkg.Goroot && !*stdFlag {
+						continue
+					}
+					if *explainFlag {
+						if anc := nearestUsedAncestor(pkg, dirIndex, used); anc != "" {
+							fmt.Printf("%s (repo kept alive by sibling %s)\n", path, anc)
+							continue
+						}
+					}
+					fmt.Println(path)
+				}
+			}
 		}
 	}
 }
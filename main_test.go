@@ -0,0 +1,93 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindGitAtSrcRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &build.Package{Dir: root, SrcRoot: root}
+	if got := findGit(pkg); got != root {
+		t.Errorf("findGit(%+v) = %q, want %q (the package dir is the git root itself)", pkg, got, root)
+	}
+}
+
+func TestFindGitAboveSrcRoot(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &build.Package{Dir: sub, SrcRoot: root}
+	if got := findGit(pkg); got != root {
+		t.Errorf("findGit(%+v) = %q, want %q", pkg, got, root)
+	}
+}
+
+func TestFindGitNone(t *testing.T) {
+	root := t.TempDir()
+	pkg := &build.Package{Dir: root, SrcRoot: root}
+	if got := findGit(pkg); got != "" {
+		t.Errorf("findGit(%+v) = %q, want \"\"", pkg, got)
+	}
+}
+
+// TestFindGitEmptyDir guards against a real hang: packageToBuildPackage
+// leaves Dir unset for module-loaded packages with no GoFiles (e.g.
+// "unsafe" or a cgo-only package), and path.Dir("") never advances past
+// ".", so the old unconditional loop spun forever instead of returning.
+func TestFindGitEmptyDir(t *testing.T) {
+	pkg := &build.Package{Dir: "", SrcRoot: "/some/module"}
+	done := make(chan string, 1)
+	go func() { done <- findGit(pkg) }()
+	select {
+	case got := <-done:
+		if got != "" {
+			t.Errorf("findGit(%+v) = %q, want \"\"", pkg, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("findGit did not return for a package with an empty Dir")
+	}
+}
+
+// TestFindGitSrcRootNotAncestor guards the same class of hang for a
+// pkg.SrcRoot that isn't actually an ancestor of pkg.Dir.
+func TestFindGitSrcRootNotAncestor(t *testing.T) {
+	pkg := &build.Package{Dir: "/a/b/c", SrcRoot: "/x/y/z"}
+	done := make(chan string, 1)
+	go func() { done <- findGit(pkg) }()
+	select {
+	case got := <-done:
+		if got != "" {
+			t.Errorf("findGit(%+v) = %q, want \"\"", pkg, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("findGit did not return when SrcRoot is not an ancestor of Dir")
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var tagsFlag = flag.String("tags", "", "comma-separated build tags to pass to the module loader and the GOPATH scan")
+
+// inModule reports whether the current working directory is inside a Go
+// module, i.e. whether "go env GOMOD" names a real go.mod file.
+func inModule() bool {
+	out, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		return false
+	}
+	gomod := strings.TrimSpace(string(out))
+	return gomod != "" && gomod != os.DevNull
+}
+
+// loadModulePackages loads patterns (e.g. "./...") with go/packages, which
+// understands modules, vendor directories and replace/exclude directives,
+// and returns them keyed by import path in the same shape the GOPATH walk
+// in main.go produces, so the rest of the program can treat the two sources
+// of packages identically.
+func loadModulePackages(patterns []string) (map[string]*build.Package, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedImports | packages.NeedDeps |
+			packages.NeedFiles | packages.NeedModule,
+		Tests: true,
+	}
+	if *tagsFlag != "" {
+		cfg.BuildFlags = []string{"-tags", *tagsFlag}
+	}
+
+	loaded, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("loading packages: %w", err)
+	}
+	if packages.PrintErrors(loaded) > 0 {
+		fmt.Fprintln(os.Stderr, "deadleaves: continuing despite package load errors above")
+	}
+
+	pkgs := make(map[string]*build.Package)
+	testImports := make(map[string][]string)
+	testGoFiles := make(map[string][]string)
+	xTestGoFiles := make(map[string][]string)
+
+	seen := make(map[*packages.Package]bool)
+	var visit func(p *packages.Package)
+	visit = func(p *packages.Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		for _, dep := range p.Imports {
+			visit(dep)
+		}
+
+		// Test variants only carry the " [x.test]" suffix on ID, never on
+		// PkgPath (PkgPath is shared by e.g. "example.com/m" the library and
+		// "example.com/m [example.com/m.test]" the internal test binary) -
+		// so the variant check has to run against ID. The external variant's
+		// package name gets a "_test" suffix (e.g. "foo_test"); the internal
+		// variant keeps foo's own name - that also tells splitTestVariant
+		// whether PkgPath itself has a synthetic "_test" suffix to undo.
+		external := strings.HasSuffix(p.Name, "_test")
+		base, isTestVariant := splitTestVariant(p.ID, external)
+		if isTestVariant {
+			for imp := range p.Imports {
+				testImports[base] = append(testImports[base], imp)
+			}
+			if external {
+				xTestGoFiles[base] = append(xTestGoFiles[base], baseNames(p.GoFiles)...)
+			} else {
+				testGoFiles[base] = append(testGoFiles[base], baseNames(p.GoFiles)...)
+			}
+			return
+		}
+
+		bp := packageToBuildPackage(p)
+		pkgs[p.PkgPath] = bp
+	}
+	for _, p := range loaded {
+		visit(p)
+	}
+
+	for path, extra := range testImports {
+		bp, ok := pkgs[path]
+		if !ok {
+			continue
+		}
+		bp.TestImports = mergeUnique(bp.TestImports, extra)
+	}
+	for path, files := range testGoFiles {
+		if bp, ok := pkgs[path]; ok {
+			bp.TestGoFiles = files
+		}
+	}
+	for path, files := range xTestGoFiles {
+		if bp, ok := pkgs[path]; ok {
+			bp.XTestGoFiles = files
+		}
+	}
+
+	return pkgs, nil
+}
+
+// splitTestVariant reports whether id names a synthetic test variant
+// produced by go/packages when Config.Tests is set (e.g. "foo [foo.test]"
+// for the internal variant or "foo_test [foo.test]" for the external one),
+// and if so returns the import path of the package it augments. external
+// must be true only for the external variant, whose PkgPath genuinely has a
+// synthetic "_test" suffix appended - the internal variant's PkgPath is
+// foo's own unmodified import path, so blindly trimming a trailing "_test"
+// there would mangle a real package whose name happens to end in "_test".
+func splitTestVariant(id string, external bool) (base string, isTestVariant bool) {
+	i := strings.Index(id, " [")
+	if i < 0 {
+		return id, false
+	}
+	base = id[:i]
+	if external {
+		base = strings.TrimSuffix(base, "_test")
+	}
+	return base, true
+}
+
+// packageToBuildPackage converts a loaded packages.Package into the
+// build.Package shape the rest of deadleaves already knows how to handle.
+// Only the fields recordDeps, findGit, autoRoot and the -std/-installed/-git
+// flags actually inspect are populated; TestGoFiles and XTestGoFiles are
+// filled in by the caller once the matching test-variant packages are seen.
+func packageToBuildPackage(p *packages.Package) *build.Package {
+	bp := &build.Package{
+		ImportPath: p.PkgPath,
+		Name:       packageName(p),
+	}
+	if len(p.GoFiles) > 0 {
+		bp.Dir = filepath.Dir(p.GoFiles[0])
+	}
+	if p.Module != nil {
+		bp.SrcRoot = p.Module.Dir
+	} else {
+		// No module info means this is a standard library package:
+		// go/packages resolves GOROOT packages without a Module.
+		bp.Goroot = true
+	}
+	// go/packages doesn't label which GoFiles use cgo individually, so treat
+	// them all as candidates; autoRoot's //export scan is a no-op on a file
+	// that doesn't have one. Skip the stdlib, though: it can't meaningfully
+	// export a //export symbol into this build, and scanning every
+	// transitively-loaded stdlib package's source on every run (hundreds of
+	// files for anything importing e.g. net/http) would dwarf the cost of
+	// the rare package that does.
+	if !bp.Goroot {
+		bp.CgoFiles = baseNames(p.GoFiles)
+	}
+	for imp := range p.Imports {
+		// Defensive: Imports map keys are plain import paths in practice and
+		// never carry the bracketed suffix, but guard against it anyway in
+		// case a future go/packages version starts keying them by ID.
+		if base, ok := splitTestVariant(imp, false); ok {
+			imp = base
+		}
+		bp.Imports = append(bp.Imports, imp)
+	}
+	return bp
+}
+
+// packageName returns "main" for command packages so callers can keep
+// treating build.Package.Name == "main" as the root-selection test.
+func packageName(p *packages.Package) string {
+	if p.Name == "main" {
+		return "main"
+	}
+	return p.Name
+}
+
+// baseNames strips go/packages' absolute paths down to the bare filenames
+// build.Package's CgoFiles/TestGoFiles/XTestGoFiles expect, since consumers
+// like autoRoot join them back onto pkg.Dir themselves.
+func baseNames(paths []string) []string {
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = filepath.Base(p)
+	}
+	return names
+}
+
+func mergeUnique(existing, extra []string) []string {
+	have := make(map[string]bool, len(existing))
+	for _, s := range existing {
+		have[s] = true
+	}
+	for _, s := range extra {
+		if !have[s] {
+			existing = append(existing, s)
+			have[s] = true
+		}
+	}
+	return existing
+}
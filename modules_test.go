@@ -0,0 +1,87 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadModulePackagesPopulatesAutoRootFiles is a regression test for
+// autoRoot being unreachable dead code for module-loaded packages:
+// packageToBuildPackage never populated CgoFiles/TestGoFiles/XTestGoFiles,
+// so a module package with an external TestMain was never auto-rooted.
+func TestLoadModulePackagesPopulatesAutoRootFiles(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "go.mod"), "module example.com/autoroottest\n\ngo 1.21\n")
+	mustWrite(t, filepath.Join(dir, "lib.go"), "package lib\n\nfunc Hello() string { return \"hi\" }\n")
+	mustWrite(t, filepath.Join(dir, "lib_x_test.go"),
+		"package lib_test\n\nimport (\n\t\"os\"\n\t\"testing\"\n)\n\nfunc TestMain(m *testing.M) { os.Exit(m.Run()) }\n")
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	pkgs, err := loadModulePackages([]string{"./..."})
+	if err != nil {
+		t.Fatalf("loadModulePackages: %v", err)
+	}
+
+	pkg, ok := pkgs["example.com/autoroottest"]
+	if !ok {
+		t.Fatalf("loadModulePackages did not return example.com/autoroottest; got %v", pkgs)
+	}
+	if len(pkg.XTestGoFiles) != 1 {
+		t.Fatalf("pkg.XTestGoFiles = %v, want one entry for lib_x_test.go", pkg.XTestGoFiles)
+	}
+	if !autoRoot(pkg) {
+		t.Errorf("autoRoot(%+v) = false, want true: the package has an external TestMain", pkg)
+	}
+}
+
+func mustWrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitTestVariant(t *testing.T) {
+	cases := []struct {
+		id            string
+		external      bool
+		wantBase      string
+		wantIsVariant bool
+	}{
+		{"example.com/m/foo", false, "example.com/m/foo", false},
+		{"example.com/m/foo [example.com/m/foo.test]", false, "example.com/m/foo", true},
+		{"example.com/m/foo_test [example.com/m/foo.test]", true, "example.com/m/foo", true},
+		// A real package whose name happens to end in "_test" must not be
+		// mangled when it's the internal (non-external) variant.
+		{"example.com/m/db_test [example.com/m/db_test.test]", false, "example.com/m/db_test", true},
+	}
+	for _, c := range cases {
+		base, isVariant := splitTestVariant(c.id, c.external)
+		if base != c.wantBase || isVariant != c.wantIsVariant {
+			t.Errorf("splitTestVariant(%q, %v) = (%q, %v), want (%q, %v)", c.id, c.external, base, isVariant, c.wantBase, c.wantIsVariant)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// stringList collects the values of a repeatable flag, e.g. -root.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+var rootFlags stringList
+var rootsFromFlag = flag.String("roots-from", "", "file of newline-delimited import paths or globs to treat as extra roots")
+
+func init() {
+	flag.Var(&rootFlags, "root", "import path or glob to treat as an extra root; may be repeated")
+}
+
+var exportDirective = regexp.MustCompile(`(?m)^//export\s+\w+`)
+var testMainFunc = regexp.MustCompile(`\bfunc\s+TestMain\s*\(`)
+
+// autoRoot reports whether pkg should be treated as a root even though it
+// isn't "package main": it exports cgo symbols, or it has a test binary
+// with its own TestMain. Go plugins are "package main" themselves, so the
+// existing package-main check already covers buildmode=plugin.
+//
+// It only opens files go/build already flagged as cgo or test sources
+// (pkg.CgoFiles/TestGoFiles/XTestGoFiles, which the package cache in
+// cache.go preserves), so ordinary packages - the overwhelming majority -
+// cost nothing here.
+func autoRoot(pkg *build.Package) bool {
+	for _, f := range pkg.CgoFiles {
+		if containsMatch(filepath.Join(pkg.Dir, f), exportDirective) {
+			return true
+		}
+	}
+	for _, f := range append(append([]string{}, pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+		if containsMatch(filepath.Join(pkg.Dir, f), testMainFunc) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMatch(file string, re *regexp.Regexp) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	return re.Match(data)
+}
+
+// explicitRoots resolves -root patterns (plain import paths or path.Match
+// globs) against the known packages.
+func explicitRoots(pkgs map[string]*build.Package, patterns []string) []*build.Package {
+	var roots []*build.Package
+	for _, pat := range patterns {
+		matched := false
+		for importPath, pkg := range pkgs {
+			if importPath == pat {
+				roots = append(roots, pkg)
+				matched = true
+				continue
+			}
+			if ok, err := path.Match(pat, importPath); err == nil && ok {
+				roots = append(roots, pkg)
+				matched = true
+			}
+		}
+		if !matched {
+			fmt.Fprintf(os.Stderr, "-root %q matched no known package\n", pat)
+		}
+	}
+	return roots
+}
+
+// rootsFromFile reads a newline-delimited allowlist of import paths or
+// globs, skipping blank lines and "#" comments.
+func rootsFromFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, sc.Err()
+}